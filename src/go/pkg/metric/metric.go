@@ -21,9 +21,14 @@
 package metric
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 	"zabbix.com/pkg/zbxerr"
 )
 
@@ -35,11 +40,142 @@ const (
 	kindGeneral
 )
 
+func (k paramKind) String() string {
+	switch k {
+	case kindSession:
+		return "session"
+	case kindConn:
+		return "conn"
+	default:
+		return "general"
+	}
+}
+
 const (
 	required = true
 	optional = false
 )
 
+// ParamType describes the Go type a parameter's raw string value should be coerced to.
+type ParamType int
+
+// Supported parameter types for EvalParamsTyped.
+const (
+	String ParamType = iota
+	Int
+	Uint
+	Float
+	Bool
+	Duration
+	Enum
+	Regex
+)
+
+// String returns the type's name as used in a MetricDescriptor's exported schema.
+func (t ParamType) String() string {
+	switch t {
+	case Int:
+		return "int"
+	case Uint:
+		return "uint"
+	case Float:
+		return "float"
+	case Bool:
+		return "bool"
+	case Duration:
+		return "duration"
+	case Enum:
+		return "enum"
+	case Regex:
+		return "regex"
+	default:
+		return "string"
+	}
+}
+
+// parseTyped converts a raw string value to the Go value matching t.
+// String and Enum are returned verbatim, since both are represented as strings;
+// Enum validity is expected to be enforced by a validator, not by this conversion.
+func parseTyped(t ParamType, value string) (interface{}, error) {
+	switch t {
+	case String, Enum:
+		return value, nil
+	case Int:
+		return strconv.ParseInt(value, 10, 64)
+	case Uint:
+		return strconv.ParseUint(value, 10, 64)
+	case Float:
+		return strconv.ParseFloat(value, 64)
+	case Bool:
+		return strconv.ParseBool(value)
+	case Duration:
+		return time.ParseDuration(value)
+	case Regex:
+		return regexp.Compile(value)
+	default:
+		return value, nil
+	}
+}
+
+// zeroTyped returns the zero value of the Go type a ParamType coerces to, used when
+// an optional parameter without a default is left unset.
+func zeroTyped(t ParamType) interface{} {
+	switch t {
+	case Int:
+		return int64(0)
+	case Uint:
+		return uint64(0)
+	case Float:
+		return float64(0)
+	case Bool:
+		return false
+	case Duration:
+		return time.Duration(0)
+	case Regex:
+		return (*regexp.Regexp)(nil)
+	default:
+		return ""
+	}
+}
+
+// Validator describes an interface for parameter value validation.
+type Validator interface {
+	Validate(value *string) error
+}
+
+// DescribableValidator is a Validator that can describe its own constraints (e.g. "one of:
+// a, b, c", "matches ^\d+$") for MetricSet.Describe. Validators that don't implement it
+// fall back to describeValidator's default, their Go type name.
+type DescribableValidator interface {
+	Validator
+	Describe() string
+}
+
+// describeValidator returns a human-readable description of v's constraints, used to
+// populate ParamDescriptor.Validator.
+func describeValidator(v Validator) string {
+	if d, ok := v.(DescribableValidator); ok {
+		return d.Describe()
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// sensitiveMask replaces a sensitive parameter's value wherever it could otherwise leak,
+// e.g. in a validation error message.
+const sensitiveMask = "***"
+
+// redactValidationErr replaces err with a generic one when sensitive is set, since the
+// original error from a Validator (or from type coercion) may otherwise echo the bad
+// value verbatim.
+func redactValidationErr(sensitive bool, err error) error {
+	if !sensitive || err == nil {
+		return err
+	}
+
+	return fmt.Errorf("value %s", sensitiveMask)
+}
+
 // Param stores parameters' metadata.
 type Param struct {
 	name         string
@@ -47,6 +183,10 @@ type Param struct {
 	required     bool
 	validator    Validator
 	defaultValue *string
+	paramType    ParamType
+	typedDefault interface{}
+	repeated     bool
+	sensitive    bool
 }
 
 func newParam(name string, kind paramKind, required bool, validator Validator) *Param {
@@ -60,6 +200,7 @@ func newParam(name string, kind paramKind, required bool, validator Validator) *
 		required:     required,
 		validator:    validator,
 		defaultValue: nil,
+		paramType:    String,
 	}
 }
 
@@ -75,6 +216,17 @@ func NewConnParam(name string) *Param {
 	return newParam(name, kindConn, optional, nil)
 }
 
+// NewRepeatedParam creates a parameter that, instead of a single value, collects every raw
+// argument from its position to the end of the passed parameters. It must be the last
+// parameter in a metric's schema. A validator set with WithValidator runs against each
+// collected element individually. See Metric.EvalRepeatedParams for retrieving the result.
+func NewRepeatedParam(name string) *Param {
+	p := newParam(name, kindGeneral, optional, nil)
+	p.repeated = true
+
+	return p
+}
+
 // WithSession transforms a connection typed parameter to a dual purpose parameter which can be either
 // a connection parameter or session name.
 // Returns a pointer.
@@ -107,6 +259,14 @@ func (p *Param) WithValidator(validator Validator) *Param {
 	return p
 }
 
+// WithType sets the type a parameter's raw string value is coerced to by EvalParamsTyped.
+// The validator, if any, still runs against the raw string value.
+func (p *Param) WithType(paramType ParamType) *Param {
+	p.paramType = paramType
+
+	return p
+}
+
 // SetRequired makes the parameter mandatory.
 // It panics if default value is specified for required parameter.
 func (p *Param) SetRequired() *Param {
@@ -119,11 +279,30 @@ func (p *Param) SetRequired() *Param {
 	return p
 }
 
+// SetSensitive marks the parameter as holding a secret (password, token, DSN fragment, etc).
+// Its resolved value is masked by Metric.RedactParams and in validation error messages.
+func (p *Param) SetSensitive() *Param {
+	p.sensitive = true
+
+	return p
+}
+
 // Metric stores a description of a metric and its parameters.
 type Metric struct {
-	description string
-	params      []*Param
-	varParam    bool
+	description   string
+	params        []*Param
+	varParam      bool
+	repeatedParam *Param
+	namedArgs     bool
+}
+
+// WithNamedParams opts a metric into addressing its parameters by name=value pairs
+// in rawParams, regardless of their position; see Metric.EvalParams.
+// Returns a pointer.
+func (m *Metric) WithNamedParams() *Metric {
+	m.namedArgs = true
+
+	return m
 }
 
 func ordinalize(num int) string {
@@ -153,6 +332,7 @@ func ordinalize(num int) string {
 // 2. It's forbidden to duplicate parameters' names.
 // 3. Session must be placed first.
 // 4. Connection parameters must be placed in a row.
+// 5. A repeated parameter (see NewRepeatedParam) must be placed last, and only one is allowed.
 func New(description string, params []*Param, varParam bool) *Metric {
 	connParamIdx := -1
 
@@ -163,6 +343,7 @@ func New(description string, params []*Param, varParam bool) *Metric {
 	}
 
 	paramsMap := make(map[string]bool)
+	var repeatedParam *Param
 
 	for i, p := range params {
 		if _, exists := paramsMap[p.name]; exists {
@@ -183,18 +364,46 @@ func New(description string, params []*Param, varParam bool) *Metric {
 			connParamIdx = i
 		}
 
-		if p.validator != nil && p.defaultValue != nil {
-			if err := p.validator.Validate(p.defaultValue); err != nil {
+		if p.repeated {
+			if i != len(params)-1 {
+				panic("repeated parameter must be placed last")
+			}
+
+			repeatedParam = p
+		}
+
+		if p.defaultValue != nil {
+			defaultDisplay := *p.defaultValue
+			if p.sensitive {
+				defaultDisplay = sensitiveMask
+			}
+
+			if p.validator != nil {
+				if err := p.validator.Validate(p.defaultValue); err != nil {
+					panic(fmt.Sprintf("invalid default value %q for %s parameter %q: %s",
+						defaultDisplay, ordinalize(i+1), p.name, redactValidationErr(p.sensitive, err).Error()))
+				}
+			}
+
+			typedDefault, err := parseTyped(p.paramType, *p.defaultValue)
+			if err != nil {
 				panic(fmt.Sprintf("invalid default value %q for %s parameter %q: %s",
-					*p.defaultValue, ordinalize(i+1), p.name, err.Error()))
+					defaultDisplay, ordinalize(i+1), p.name, redactValidationErr(p.sensitive, err).Error()))
 			}
+
+			p.typedDefault = typedDefault
 		}
 	}
 
+	if repeatedParam != nil {
+		varParam = true
+	}
+
 	return &Metric{
-		description: description,
-		params:      params,
-		varParam:    varParam,
+		description:   description,
+		params:        params,
+		varParam:      varParam,
+		repeatedParam: repeatedParam,
 	}
 }
 
@@ -214,7 +423,104 @@ func findSession(name string, sessions interface{}) (session interface{}) {
 	return
 }
 
-func mergeWithSessionData(out map[string]string, metricParams []*Param, session interface{}) error {
+// splitNamedParam splits a raw parameter shaped name=value into its two parts.
+// ok is false if raw does not have that shape.
+func splitNamedParam(raw string) (name, value string, ok bool) {
+	idx := strings.IndexByte(raw, '=')
+	if idx <= 0 {
+		return "", "", false
+	}
+
+	return raw[:idx], raw[idx+1:], true
+}
+
+func paramIndexByName(params []*Param, name string) int {
+	for i, p := range params {
+		if p.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// resolveNamedParams moves any name=value element into the slot of the parameter called
+// name, with remaining unnamed elements filling the leftover slots in order.
+// It is a no-op unless the metric was created with WithNamedParams.
+func (m *Metric) resolveNamedParams(rawParams []string) ([]string, error) {
+	if !m.namedArgs {
+		return rawParams, nil
+	}
+
+	fixed := m.params
+	if m.repeatedParam != nil {
+		fixed = m.params[:len(m.params)-1]
+	}
+
+	fixedRaw := rawParams
+	if len(rawParams) > len(fixed) {
+		fixedRaw = rawParams[:len(fixed)]
+	}
+
+	named := make(map[string]string)
+	positional := make([]string, 0, len(fixedRaw))
+
+	for _, raw := range fixedRaw {
+		name, value, isNamed := splitNamedParam(raw)
+		if !isNamed {
+			positional = append(positional, raw)
+			continue
+		}
+
+		idx := paramIndexByName(fixed, name)
+		if idx < 0 {
+			return nil, zbxerr.ErrorInvalidParams.Wrap(fmt.Errorf("unknown parameter %q", name))
+		}
+
+		if idx == 0 && fixed[0].kind == kindSession {
+			return nil, zbxerr.ErrorInvalidParams.Wrap(
+				fmt.Errorf("session parameter %q cannot be passed along with name=value parameters", name))
+		}
+
+		if _, exists := named[name]; exists {
+			return nil, zbxerr.ErrorInvalidParams.Wrap(fmt.Errorf("parameter %q specified more than once", name))
+		}
+
+		named[name] = value
+	}
+
+	if len(named) == 0 {
+		return rawParams, nil
+	}
+
+	resolved := make([]string, len(fixed))
+	posIdx := 0
+
+	for i, p := range fixed {
+		if v, ok := named[p.name]; ok {
+			resolved[i] = v
+			continue
+		}
+
+		if posIdx < len(positional) {
+			if i == 0 && p.kind == kindSession && positional[posIdx] != "" {
+				return nil, zbxerr.ErrorInvalidParams.Wrap(
+					fmt.Errorf("session parameter %q cannot be passed along with name=value parameters", p.name))
+			}
+
+			resolved[i] = positional[posIdx]
+			posIdx++
+		}
+	}
+
+	if len(rawParams) > len(fixed) {
+		resolved = append(resolved, rawParams[len(fixed):]...)
+	}
+
+	return resolved, nil
+}
+
+func mergeWithSessionData(out map[string]string, typedOut map[string]interface{}, metricParams []*Param, session interface{}) error {
 	v := reflect.ValueOf(session)
 	for i := 0; i < v.NumField(); i++ {
 		var p *Param = nil
@@ -248,33 +554,53 @@ func mergeWithSessionData(out map[string]string, metricParams []*Param, session
 
 		if p.validator != nil {
 			if err := p.validator.Validate(&val); err != nil {
-				return zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).Wrap(err)
+				return zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).
+					Wrap(redactValidationErr(p.sensitive, err))
 			}
 		}
 
 		out[p.name] = val
+
+		if typedOut != nil {
+			if val == "" {
+				typedOut[p.name] = zeroTyped(p.paramType)
+				continue
+			}
+
+			typedVal, err := parseTyped(p.paramType, val)
+			if err != nil {
+				return zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).
+					Wrap(redactValidationErr(p.sensitive, err))
+			}
+
+			typedOut[p.name] = typedVal
+		}
 	}
 
 	return nil
 }
 
-// EvalParams returns a mapping of parameters' names to their values passed to a plugin and/or
-// sessions specified in the configuration file.
-// If a session is configured, then an other connection parameters must not be accepted and an error will be returned.
-// Also it returns error in following cases:
-// * incorrect number of parameters are passed;
-// * missing required parameter;
-// * value validation is failed.
-func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params map[string]string, err error) {
+// evalParams is the shared implementation behind EvalParams and EvalParamsTyped.
+// typedParams is filled in alongside params only when the caller passes a non-nil map.
+func (m *Metric) evalParams(rawParams []string, sessions interface{}, typedParams map[string]interface{}) (
+	params map[string]string, err error) {
 	var (
 		session interface{}
 		val     *string
 	)
 
+	if rawParams, err = m.resolveNamedParams(rawParams); err != nil {
+		return nil, err
+	}
+
 	if !m.varParam && len(rawParams) > len(m.params) {
 		return nil, zbxerr.ErrorTooManyParameters
 	}
 
+	if err = repeatedTailRequired(m.repeatedParam, len(m.params)-1, rawParams); err != nil {
+		return nil, err
+	}
+
 	if len(rawParams) > 0 && m.params[0].kind == kindSession {
 		session = findSession(rawParams[0], sessions)
 	}
@@ -282,6 +608,10 @@ func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params ma
 	params = make(map[string]string)
 
 	for i, p := range m.params {
+		if p.repeated {
+			continue
+		}
+
 		kind := p.kind
 		if kind == kindSession {
 			if session != nil {
@@ -314,7 +644,8 @@ func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params ma
 
 		if p.validator != nil && skipConnIfSessionIsSet {
 			if err = p.validator.Validate(val); err != nil {
-				return nil, zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).Wrap(err)
+				return nil, zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).
+					Wrap(redactValidationErr(p.sensitive, err))
 			}
 		}
 
@@ -330,11 +661,25 @@ func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params ma
 		if kind == kindGeneral {
 			params[p.name] = *val
 		}
+
+		if typedParams != nil && (kind == kindGeneral || (kind == kindConn && session == nil)) {
+			if val == p.defaultValue {
+				typedParams[p.name] = p.typedDefault
+			} else {
+				typedVal, terr := parseTyped(p.paramType, *val)
+				if terr != nil {
+					return nil, zbxerr.New(fmt.Sprintf("invalid %s parameter %q", ordNum, p.name)).
+						Wrap(redactValidationErr(p.sensitive, terr))
+				}
+
+				typedParams[p.name] = typedVal
+			}
+		}
 	}
 
 	// Fill connection parameters with data from a session
 	if session != nil {
-		if err = mergeWithSessionData(params, m.params, session); err != nil {
+		if err = mergeWithSessionData(params, typedParams, m.params, session); err != nil {
 			return nil, err
 		}
 	}
@@ -342,6 +687,136 @@ func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params ma
 	return params, nil
 }
 
+// EvalParams returns a mapping of parameters' names to their values passed to a plugin and/or
+// sessions specified in the configuration file.
+// If a session is configured, then an other connection parameters must not be accepted and an error will be returned.
+// Also it returns error in following cases:
+// * incorrect number of parameters are passed;
+// * missing required parameter;
+// * value validation is failed.
+func (m *Metric) EvalParams(rawParams []string, sessions interface{}) (params map[string]string, err error) {
+	return m.evalParams(rawParams, sessions, nil)
+}
+
+// EvalParamsTyped behaves like EvalParams, but returns each parameter's value already coerced
+// to the Go type set by Param.WithType, instead of leaving every value as a raw string.
+// Parameters without an explicit type are returned as strings, same as EvalParams.
+func (m *Metric) EvalParamsTyped(rawParams []string, sessions interface{}) (params map[string]interface{}, err error) {
+	typedParams := make(map[string]interface{})
+
+	if _, err = m.evalParams(rawParams, sessions, typedParams); err != nil {
+		return nil, err
+	}
+
+	return typedParams, nil
+}
+
+// repeatedTailRequired errors if repeated is required but rawParams doesn't reach its
+// trailing slot at tailStart.
+func repeatedTailRequired(repeated *Param, tailStart int, rawParams []string) error {
+	if repeated == nil || !repeated.required || len(rawParams) > tailStart {
+		return nil
+	}
+
+	return zbxerr.ErrorTooFewParameters.Wrap(
+		fmt.Errorf("repeated parameter %q is required", repeated.name))
+}
+
+// EvalRepeatedParams returns the values collected by a metric's trailing repeated
+// parameter (declared with NewRepeatedParam), grouped by name=value on a metric created
+// with WithNamedParams and under the parameter's own name otherwise. It returns nil, nil
+// for a metric with no repeated parameter. It must be called with the same rawParams
+// passed to EvalParams. See Metric.RedactRepeatedParams for logging a sensitive result.
+func (m *Metric) EvalRepeatedParams(rawParams []string) (map[string][]string, error) {
+	if m.repeatedParam == nil {
+		return nil, nil
+	}
+
+	tailStart := len(m.params) - 1
+	if len(rawParams) <= tailStart {
+		if err := repeatedTailRequired(m.repeatedParam, tailStart, rawParams); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	out := make(map[string][]string)
+
+	for _, raw := range rawParams[tailStart:] {
+		name, value := m.repeatedParam.name, raw
+
+		if m.namedArgs {
+			if n, v, ok := splitNamedParam(raw); ok {
+				name, value = n, v
+			}
+		}
+
+		if m.repeatedParam.validator != nil {
+			if err := m.repeatedParam.validator.Validate(&value); err != nil {
+				return nil, zbxerr.New(fmt.Sprintf("invalid repeated parameter %q", name)).
+					Wrap(redactValidationErr(m.repeatedParam.sensitive, err))
+			}
+		}
+
+		out[name] = append(out[name], value)
+	}
+
+	return out, nil
+}
+
+// RedactParams returns a copy of params with every value belonging to a parameter marked
+// sensitive (see Param.SetSensitive) replaced by "***", safe to log or include in error
+// messages. Values resolved from a session are redacted the same as inline ones, since
+// both are keyed by the same schema Param. See Metric.RedactRepeatedParams for the
+// map[string][]string returned by EvalRepeatedParams.
+func (m *Metric) RedactParams(params map[string]string) map[string]string {
+	redacted := make(map[string]string, len(params))
+
+	for name, value := range params {
+		redacted[name] = value
+	}
+
+	for _, p := range m.params {
+		if !p.sensitive {
+			continue
+		}
+
+		if _, ok := redacted[p.name]; ok {
+			redacted[p.name] = sensitiveMask
+		}
+	}
+
+	return redacted
+}
+
+// RedactRepeatedParams returns a copy of repeated (as obtained from EvalRepeatedParams)
+// safe to log or include in error messages: every value is replaced by "***" when the
+// metric's trailing repeated parameter is marked sensitive (see Param.SetSensitive). A
+// repeated parameter has no per-group schema, so sensitivity can't be judged group by
+// group; it's all-or-nothing for the whole repeated parameter.
+func (m *Metric) RedactRepeatedParams(repeated map[string][]string) map[string][]string {
+	redacted := make(map[string][]string, len(repeated))
+
+	sensitive := m.repeatedParam != nil && m.repeatedParam.sensitive
+
+	for name, values := range repeated {
+		if !sensitive {
+			redacted[name] = values
+			continue
+		}
+
+		masked := make([]string, len(values))
+		for i := range values {
+			masked[i] = sensitiveMask
+		}
+
+		redacted[name] = masked
+	}
+
+	return redacted
+}
+
 // MetricSet stores a mapping of keys to metrics.
 type MetricSet map[string]*Metric
 
@@ -353,3 +828,84 @@ func (ml MetricSet) List() (list []string) {
 
 	return
 }
+
+// ParamDescriptor is the machine-readable description of a single metric parameter.
+type ParamDescriptor struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Required  bool    `json:"required"`
+	Default   *string `json:"default,omitempty"`
+	Type      string  `json:"type"`
+	Validator string  `json:"validator,omitempty"`
+	Sensitive bool    `json:"sensitive,omitempty"`
+	Repeated  bool    `json:"repeated,omitempty"`
+}
+
+func (p *Param) describe() ParamDescriptor {
+	desc := ParamDescriptor{
+		Name:      p.name,
+		Kind:      p.kind.String(),
+		Required:  p.required,
+		Default:   p.defaultValue,
+		Type:      p.paramType.String(),
+		Sensitive: p.sensitive,
+		Repeated:  p.repeated,
+	}
+
+	if p.sensitive && desc.Default != nil {
+		masked := sensitiveMask
+		desc.Default = &masked
+	}
+
+	if p.validator != nil {
+		desc.Validator = describeValidator(p.validator)
+	}
+
+	return desc
+}
+
+// MetricDescriptor is the machine-readable description of a single metric: its key,
+// description and full parameter schema.
+type MetricDescriptor struct {
+	Key         string            `json:"key"`
+	Description string            `json:"description"`
+	Params      []ParamDescriptor `json:"params"`
+	VarParam    bool              `json:"varParam,omitempty"`
+}
+
+// Describe returns a machine-readable description of the metric. Key is left empty; it's
+// filled in by MetricSet.Describe, which is the one that knows under which key the metric
+// is registered.
+func (m *Metric) Describe() MetricDescriptor {
+	params := make([]ParamDescriptor, 0, len(m.params))
+	for _, p := range m.params {
+		params = append(params, p.describe())
+	}
+
+	return MetricDescriptor{
+		Description: m.description,
+		Params:      params,
+		VarParam:    m.varParam,
+	}
+}
+
+// Describe returns a machine-readable description of every metric in the set, sorted by
+// key for a stable, reproducible result.
+func (ml MetricSet) Describe() []MetricDescriptor {
+	descriptors := make([]MetricDescriptor, 0, len(ml))
+
+	for key, metric := range ml {
+		d := metric.Describe()
+		d.Key = key
+		descriptors = append(descriptors, d)
+	}
+
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].Key < descriptors[j].Key })
+
+	return descriptors
+}
+
+// MarshalJSON implements json.Marshaler, emitting the same schema as Describe.
+func (ml MetricSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ml.Describe())
+}
@@ -0,0 +1,417 @@
+/*
+** Zabbix
+** Copyright (C) 2001-2020 Zabbix SIA
+**
+** This program is free software; you can redistribute it and/or modify
+** it under the terms of the GNU General Public License as published by
+** the Free Software Foundation; either version 2 of the License, or
+** (at your option) any later version.
+**
+** This program is distributed in the hope that it will be useful,
+** but WITHOUT ANY WARRANTY; without even the implied warranty of
+** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+** GNU General Public License for more details.
+**
+** You should have received a copy of the GNU General Public License
+** along with this program; if not, write to the Free Software
+** Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+**/
+
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fnValidator is a Validator (and DescribableValidator) built from a plain func, used
+// throughout this file to exercise validation without depending on a concrete validator
+// implementation.
+type fnValidator struct {
+	validate func(*string) error
+	describe string
+}
+
+func (v fnValidator) Validate(value *string) error { return v.validate(value) }
+
+func (v fnValidator) Describe() string { return v.describe }
+
+// rejectValidator returns a validator that rejects exactly one value.
+func rejectValidator(bad, msg string) fnValidator {
+	return fnValidator{
+		validate: func(s *string) error {
+			if s != nil && *s == bad {
+				return fmt.Errorf("%s: %q", msg, *s)
+			}
+
+			return nil
+		},
+		describe: msg,
+	}
+}
+
+func TestEvalParamsTyped(t *testing.T) {
+	m := New("test typed params", []*Param{
+		NewParam("count").WithType(Int).SetRequired(),
+		NewParam("ratio").WithType(Float).WithDefault("1.5"),
+		NewParam("enabled").WithType(Bool).WithDefault("false"),
+	}, false)
+
+	params, err := m.EvalParamsTyped([]string{"3"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if v, ok := params["count"].(int64); !ok || v != 3 {
+		t.Errorf("count = %#v, want int64(3)", params["count"])
+	}
+
+	if v, ok := params["ratio"].(float64); !ok || v != 1.5 {
+		t.Errorf("ratio = %#v, want float64(1.5)", params["ratio"])
+	}
+
+	if v, ok := params["enabled"].(bool); !ok || v {
+		t.Errorf("enabled = %#v, want bool(false)", params["enabled"])
+	}
+}
+
+func TestEvalParamsTypedInvalidValue(t *testing.T) {
+	m := New("test typed params", []*Param{NewParam("count").WithType(Int)}, false)
+
+	if _, err := m.EvalParamsTyped([]string{"not-a-number"}, nil); err == nil {
+		t.Fatal("expected an error for a non-numeric value on an Int parameter")
+	}
+}
+
+func TestEvalParamsTypedRegex(t *testing.T) {
+	m := New("test", []*Param{NewParam("pattern").WithType(Regex)}, false)
+
+	params, err := m.EvalParamsTyped([]string{`^\d+$`}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	re, ok := params["pattern"].(*regexp.Regexp)
+	if !ok || !re.MatchString("123") {
+		t.Errorf("pattern = %#v, want a compiled regexp matching digits", params["pattern"])
+	}
+}
+
+func TestNewPanicsOnInvalidTypedDefault(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected New to panic on a default value that fails type coercion")
+		}
+	}()
+
+	New("test", []*Param{NewParam("count").WithType(Int).WithDefault("nope")}, false)
+}
+
+func TestEvalParamsTypedSessionMerge(t *testing.T) {
+	type session struct {
+		Host string
+		Port string
+	}
+
+	m := New("test", []*Param{
+		NewConnParam("Host").WithSession(),
+		NewConnParam("Port").WithType(Int).WithDefault("10050"),
+	}, false)
+
+	sessions := map[string]session{"prod": {Host: "", Port: "10051"}}
+
+	params, err := m.EvalParamsTyped([]string{"prod"}, sessions)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if v, ok := params["Port"].(int64); !ok || v != 10051 {
+		t.Errorf("Port = %#v, want int64(10051)", params["Port"])
+	}
+}
+
+func TestEvalParamsNamedAndPositional(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("a"),
+		NewParam("b").WithDefault("b-default"),
+		NewParam("c").SetRequired(),
+	}, false).WithNamedParams()
+
+	params, err := m.EvalParams([]string{"c=c-value", "a-value"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string]string{"a": "a-value", "b": "b-default", "c": "c-value"}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %#v, want %#v", params, want)
+	}
+}
+
+func TestEvalParamsNamedUnknownName(t *testing.T) {
+	m := New("test", []*Param{NewParam("a")}, false).WithNamedParams()
+
+	if _, err := m.EvalParams([]string{"nope=value"}, nil); err == nil {
+		t.Fatal("expected an error for an unknown parameter name")
+	}
+}
+
+func TestEvalParamsNamedForbidsSession(t *testing.T) {
+	m := New("test", []*Param{
+		NewConnParam("Host").WithSession(),
+		NewParam("a"),
+	}, false).WithNamedParams()
+
+	if _, err := m.EvalParams([]string{"Host=prod"}, nil); err == nil {
+		t.Fatal("expected an error when addressing the session parameter by name")
+	}
+}
+
+func TestEvalParamsNamedRejectsDuplicateName(t *testing.T) {
+	m := New("test", []*Param{NewParam("a"), NewParam("b")}, false).WithNamedParams()
+
+	if _, err := m.EvalParams([]string{"a=1", "a=2"}, nil); err == nil {
+		t.Fatal("expected an error when a name is specified more than once")
+	}
+}
+
+func TestEvalParamsTooManyWithoutRepeated(t *testing.T) {
+	m := New("test", []*Param{NewParam("a")}, false)
+
+	if _, err := m.EvalParams([]string{"1", "2"}, nil); err == nil {
+		t.Fatal("expected a too-many-parameters error")
+	}
+}
+
+func TestEvalParamsRepeatedImpliesVarParam(t *testing.T) {
+	m := New("test", []*Param{NewParam("a"), NewRepeatedParam("tail")}, false)
+
+	params, err := m.EvalParams([]string{"1", "x", "y", "z"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if params["a"] != "1" {
+		t.Errorf(`params["a"] = %q, want "1"`, params["a"])
+	}
+}
+
+func TestEvalRepeatedParams(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("a"),
+		NewRepeatedParam("tags"),
+	}, false).WithNamedParams()
+
+	repeated, err := m.EvalRepeatedParams([]string{"a-value", "x", "tag=web", "tag=db"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string][]string{
+		"tags": {"x"},
+		"tag":  {"web", "db"},
+	}
+	if !reflect.DeepEqual(repeated, want) {
+		t.Errorf("repeated = %#v, want %#v", repeated, want)
+	}
+}
+
+func TestEvalRepeatedParamsWithoutNamedParamsKeepsRawValues(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("a"),
+		NewRepeatedParam("tags"),
+	}, false)
+
+	repeated, err := m.EvalRepeatedParams([]string{"a-value", "x", "tag=web"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string][]string{"tags": {"x", "tag=web"}}
+	if !reflect.DeepEqual(repeated, want) {
+		t.Errorf("repeated = %#v, want %#v", repeated, want)
+	}
+}
+
+func TestEvalRepeatedParamsRequired(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("a"),
+		NewRepeatedParam("tags").SetRequired(),
+	}, false)
+
+	if _, err := m.EvalRepeatedParams([]string{"a-value"}); err == nil {
+		t.Fatal("expected an error when a required repeated parameter has no values")
+	}
+}
+
+func TestEvalParamsRequiresRepeatedParam(t *testing.T) {
+	m := New("test", []*Param{NewRepeatedParam("tags").SetRequired()}, false)
+
+	if _, err := m.EvalParams([]string{}, nil); err == nil {
+		t.Fatal("expected EvalParams to error when a required repeated parameter has no values")
+	}
+}
+
+func TestEvalRepeatedParamsValidatesEachElement(t *testing.T) {
+	m := New("test", []*Param{
+		NewRepeatedParam("tags").WithValidator(rejectValidator("bad", "must not be bad")),
+	}, true)
+
+	if _, err := m.EvalRepeatedParams([]string{"good", "bad"}); err == nil {
+		t.Fatal("expected an error for a rejected repeated element")
+	}
+}
+
+func TestRedactParams(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("user"),
+		NewParam("password").SetSensitive(),
+	}, false)
+
+	params := map[string]string{"user": "alice", "password": "hunter2"}
+	redacted := m.RedactParams(params)
+
+	if redacted["password"] != sensitiveMask {
+		t.Errorf("password = %q, want %q", redacted["password"], sensitiveMask)
+	}
+
+	if redacted["user"] != "alice" {
+		t.Errorf("user = %q, want unchanged", redacted["user"])
+	}
+
+	if params["password"] != "hunter2" {
+		t.Error("RedactParams must not mutate its input")
+	}
+}
+
+func TestEvalParamsRedactsValidatorError(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("password").SetSensitive().WithValidator(rejectValidator("bad", "must not be bad")),
+	}, false)
+
+	_, err := m.EvalParams([]string{"bad"}, nil)
+	if err == nil {
+		t.Fatal("expected a validation error")
+	}
+
+	if strings.Contains(err.Error(), "bad") {
+		t.Errorf("error %q leaks the sensitive raw value", err.Error())
+	}
+}
+
+func TestNewMasksSensitiveDefaultInPanic(t *testing.T) {
+	defer func() {
+		r := recover()
+
+		msg, ok := r.(string)
+		if !ok {
+			t.Fatal("expected New to panic with a string message")
+		}
+
+		if strings.Contains(msg, "s3cr3t") {
+			t.Errorf("panic message %q leaks the sensitive default value", msg)
+		}
+	}()
+
+	New("test", []*Param{
+		NewParam("password").SetSensitive().WithDefault("s3cr3t").
+			WithValidator(rejectValidator("s3cr3t", "must not be s3cr3t")),
+	}, false)
+}
+
+func TestRedactRepeatedParams(t *testing.T) {
+	m := New("test", []*Param{NewRepeatedParam("tokens").SetSensitive()}, true)
+
+	repeated, err := m.EvalRepeatedParams([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	redacted := m.RedactRepeatedParams(repeated)
+
+	for _, v := range redacted["tokens"] {
+		if v != sensitiveMask {
+			t.Errorf("value = %q, want %q", v, sensitiveMask)
+		}
+	}
+}
+
+func TestMetricDescribe(t *testing.T) {
+	m := New("counts things", []*Param{
+		NewParam("count").WithType(Int).SetRequired(),
+		NewParam("mode").WithType(Enum).WithDefault("fast").
+			WithValidator(rejectValidator("bad", "one of: fast, slow")),
+		NewParam("password").SetSensitive(),
+	}, false)
+
+	d := m.Describe()
+
+	if d.Description != "counts things" {
+		t.Errorf("Description = %q", d.Description)
+	}
+
+	if len(d.Params) != 3 {
+		t.Fatalf("len(Params) = %d, want 3", len(d.Params))
+	}
+
+	count := d.Params[0]
+	if count.Name != "count" || count.Kind != "general" || !count.Required || count.Type != "int" {
+		t.Errorf("count descriptor = %#v", count)
+	}
+
+	mode := d.Params[1]
+	if mode.Validator != "one of: fast, slow" {
+		t.Errorf("mode.Validator = %q", mode.Validator)
+	}
+
+	if mode.Default == nil || *mode.Default != "fast" {
+		t.Errorf("mode.Default = %v", mode.Default)
+	}
+
+	password := d.Params[2]
+	if !password.Sensitive {
+		t.Error("password descriptor should be marked sensitive")
+	}
+}
+
+func TestMetricDescribeMasksSensitiveDefault(t *testing.T) {
+	m := New("test", []*Param{
+		NewParam("password").SetSensitive().WithDefault("s3cr3t"),
+	}, false)
+
+	d := m.Describe()
+
+	if d.Params[0].Default == nil || *d.Params[0].Default != sensitiveMask {
+		t.Errorf("Default = %v, want masked", d.Params[0].Default)
+	}
+}
+
+func TestMetricSetDescribeSortedAndMarshalJSON(t *testing.T) {
+	ml := MetricSet{
+		"b.key": New("b metric", []*Param{NewParam("x")}, false),
+		"a.key": New("a metric", nil, false),
+	}
+
+	descriptors := ml.Describe()
+	if len(descriptors) != 2 || descriptors[0].Key != "a.key" || descriptors[1].Key != "b.key" {
+		t.Fatalf("Describe() not sorted by key: %#v", descriptors)
+	}
+
+	data, err := json.Marshal(ml)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var roundTrip []MetricDescriptor
+	if err := json.Unmarshal(data, &roundTrip); err != nil {
+		t.Fatalf("unexpected error unmarshaling: %s", err)
+	}
+
+	if !reflect.DeepEqual(roundTrip, descriptors) {
+		t.Errorf("roundTrip = %#v, want %#v", roundTrip, descriptors)
+	}
+}